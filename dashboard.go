@@ -24,13 +24,18 @@ package dashboard
 
 import (
 	"bytes"
+	"context"
 	_ "embed" // nolint
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -49,17 +54,34 @@ func init() {
 }
 
 const (
-	pathMetrics   = "/api/metrics"
-	defaultPort   = 5665
-	defaultPeriod = 10
+	pathMetrics     = "/api/metrics"
+	pathQuery       = "/api/v1/query"
+	pathQueryRange  = "/api/v1/query_range"
+	pathLabelValues = "/api/v1/label/__name__/values"
+	pathSeries      = "/api/v1/series"
+	defaultPort     = 5665
+	defaultPeriod   = 10
 )
 
 type options struct {
-	Port   int
-	Host   string
-	Period int
-	UI     string
-	Wait   int
+	Port              int
+	Host              string
+	Period            int
+	UI                string
+	Wait              int
+	Tags              string `schema:"tags"`
+	DropTags          string `schema:"drop_tags"`
+	Retention         string `schema:"retention"`
+	RemoteWrite       string `schema:"remote_write"`
+	RemoteWriteBearer string `schema:"remote_write_bearer"`
+	RemoteWriteLabels string `schema:"remote_write_labels"`
+	Sinks             string `schema:"sinks"`
+	OTLPEndpoint      string `schema:"otlp_endpoint"`
+	StatsDAddr        string `schema:"statsd_addr"`
+	JSONLPath         string `schema:"jsonl_path"`
+	Buckets           string `schema:"buckets"`
+	BucketsConfig     string `schema:"buckets_config"`
+	NativeHistograms  bool   `schema:"native_histograms"`
 }
 
 type Output struct {
@@ -67,6 +89,12 @@ type Output struct {
 
 	*internal.PrometheusAdapter
 
+	registry       *prometheus.Registry
+	tsdb           *internal.TSDB
+	selfMetrics    *internal.SelfMetrics
+	remoteWriter   *internal.RemoteWriter
+	sinks          []internal.Sink
+	metricsEnabled bool
 	flusher        *output.PeriodicFlusher
 	addr           string
 	arg            string
@@ -77,9 +105,22 @@ type Output struct {
 }
 
 func New(params output.Params) (output.Output, error) {
+	opts, err := getopts(params.ConfigArgument)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketOverrides, err := loadBucketOverrides(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	registry := prometheus.NewRegistry()
 	o := &Output{
-		PrometheusAdapter: internal.NewPrometheusAdapter(registry, params.Logger, "", ""),
+		PrometheusAdapter: internal.NewPrometheusAdapter(registry, params.Logger, "", "", bucketOverrides, opts.NativeHistograms),
+		registry:          registry,
+		tsdb:              internal.NewTSDB(registry),
+		selfMetrics:       internal.NewSelfMetrics(registry),
 		arg:               params.ConfigArgument,
 		logger:            params.Logger,
 		flusher:           nil,
@@ -95,6 +136,57 @@ func (o *Output) Description() string {
 	return fmt.Sprintf("dashboard (%s)", o.addr)
 }
 
+// buildSinks resolves the ?sinks=... output URL parameter into the
+// corresponding Sink implementations, defaulting to just the built-in
+// Prometheus adapter when the parameter is not set.
+//
+// The Prometheus registry itself is always fed from MetricWorker,
+// independent of this list: the TSDB and Remote Write both read from it,
+// so it can't be made conditional on whatever sinks the user picked.
+// "prometheus" in ?sinks=... instead controls only whether /api/metrics
+// is exposed, which buildSinks reports via the returned bool.
+func (o *Output) buildSinks(opts *options) ([]internal.Sink, bool, error) {
+	names := splitCSV(opts.Sinks)
+	if len(names) == 0 {
+		names = []string{"prometheus"}
+	}
+
+	sinks := make([]internal.Sink, 0, len(names))
+	metricsEnabled := false
+
+	for _, name := range names {
+		switch name {
+		case "prometheus":
+			metricsEnabled = true
+		case "otlp":
+			sink, err := internal.NewOTLPSink(context.Background(), opts.OTLPEndpoint, o.logger)
+			if err != nil {
+				return nil, false, err
+			}
+
+			sinks = append(sinks, sink)
+		case "statsd":
+			sink, err := internal.NewStatsDSink(opts.StatsDAddr, o.logger)
+			if err != nil {
+				return nil, false, err
+			}
+
+			sinks = append(sinks, sink)
+		case "jsonl":
+			sink, err := internal.NewJSONLSink(opts.JSONLPath, o.logger)
+			if err != nil {
+				return nil, false, err
+			}
+
+			sinks = append(sinks, sink)
+		default:
+			return nil, false, fmt.Errorf("dashboard: unknown sink %q", name)
+		}
+	}
+
+	return sinks, metricsEnabled, nil
+}
+
 func getopts(qs string) (*options, error) {
 	opts := &options{
 		Port:   defaultPort,
@@ -127,7 +219,15 @@ func (o *Output) handler(opts *options) (http.Handler, error) {
 	}
 
 	mux := http.DefaultServeMux
-	mux.Handle(pathMetrics, o.PrometheusAdapter.Handler())
+
+	if o.metricsEnabled {
+		mux.Handle(pathMetrics, o.selfMetrics.Instrument(o.PrometheusAdapter.Handler()))
+	}
+
+	mux.Handle(pathQuery, o.selfMetrics.Instrument(http.HandlerFunc(o.tsdb.QueryHandler)))
+	mux.Handle(pathQueryRange, o.selfMetrics.Instrument(http.HandlerFunc(o.tsdb.QueryRangeHandler)))
+	mux.Handle(pathLabelValues, o.selfMetrics.Instrument(http.HandlerFunc(o.tsdb.LabelValuesHandler)))
+	mux.Handle(pathSeries, o.selfMetrics.Instrument(http.HandlerFunc(o.tsdb.SeriesHandler)))
 
 	u, err := url.Parse(opts.UI)
 	if err != nil {
@@ -147,7 +247,7 @@ func (o *Output) handler(opts *options) (http.Handler, error) {
 
 	page := buff.Bytes()
 
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/", o.selfMetrics.Instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
 			http.NotFound(w, r)
 
@@ -155,7 +255,7 @@ func (o *Output) handler(opts *options) (http.Handler, error) {
 		}
 
 		w.Write(page) // nolint:errcheck
-	})
+	})))
 
 	return mux, nil
 }
@@ -168,6 +268,16 @@ func (o *Output) Start() error {
 
 	o.addr = fmt.Sprintf("%s:%d", opts.Host, opts.Port)
 
+	o.PrometheusAdapter.SetTagFilter(splitCSV(opts.Tags), splitCSV(opts.DropTags))
+
+	sinks, metricsEnabled, err := o.buildSinks(opts)
+	if err != nil {
+		return err
+	}
+
+	o.sinks = sinks
+	o.metricsEnabled = metricsEnabled
+
 	listener, err := net.Listen("tcp", o.addr)
 	if err != nil {
 		return err
@@ -186,6 +296,19 @@ func (o *Output) Start() error {
 
 	go o.MetricWorker()
 
+	retention, err := time.ParseDuration(opts.Retention)
+	if err != nil && opts.Retention != "" {
+		return err
+	}
+
+	go o.tsdb.Run(time.Duration(opts.Period)*time.Second, retention)
+
+	if opts.RemoteWrite != "" {
+		o.remoteWriter = internal.NewRemoteWriter(
+			opts.RemoteWrite, opts.RemoteWriteBearer, parseLabels(opts.RemoteWriteLabels), o.logger, o.selfMetrics.SamplesDropped,
+		)
+	}
+
 	o.flusher, err = output.NewPeriodicFlusher(time.Duration(opts.Period)*time.Second, o.flushMetrics)
 	if err != nil {
 		return err
@@ -195,28 +318,43 @@ func (o *Output) Start() error {
 }
 
 func (o *Output) MetricWorker() {
-	for {
-		select {
-		case sampleGroup := <-o.sampleChannel:
-			go func(*Output) {
-				defer o.wg.Done()
-				defer func() {
-					atomic.AddInt64(&o.workGroupCount, -1)
-				}()
-				o.wg.Add(1)
-				atomic.AddInt64(&o.workGroupCount, 1)
-
-				for _, sc := range sampleGroup {
-					samples := sc.GetSamples()
-
-					for _, entry := range samples {
-						o.HandleSample(&entry)
+	for sampleGroup := range o.sampleChannel {
+		o.wg.Add(1)
+
+		count := atomic.AddInt64(&o.workGroupCount, 1)
+		o.selfMetrics.WorkerGoroutines.WithLabelValues("metric").Set(float64(count))
+
+		go func(sampleGroup []metrics.SampleContainer) {
+			defer o.wg.Done()
+			defer func() {
+				count := atomic.AddInt64(&o.workGroupCount, -1)
+				o.selfMetrics.WorkerGoroutines.WithLabelValues("metric").Set(float64(count))
+			}()
+
+			start := time.Now()
+			processed := 0
+
+			for _, sc := range sampleGroup {
+				samples := sc.GetSamples()
+
+				for _, entry := range samples {
+					o.selfMetrics.SamplesProcessed.WithLabelValues(entry.Metric.Type.String()).Inc()
+
+					// The registry always gets fed, regardless of ?sinks=...:
+					// the TSDB and Remote Write both read from it, so it
+					// can't be tied to whether "prometheus" is selected.
+					o.PrometheusAdapter.HandleSample(&entry)
+
+					for _, sink := range o.sinks {
+						sink.HandleSample(&entry)
 					}
 
+					processed++
 				}
+			}
 
-			}(o)
-		}
+			o.selfMetrics.ObserveFlush(time.Since(start), processed)
+		}(sampleGroup)
 	}
 }
 
@@ -246,12 +384,41 @@ func (o *Output) flushMetrics() {
 
 	}
 	o.logger.WithField("Count", o.workGroupCount).Info("Work Group")
+
+	for _, sink := range o.sinks {
+		if err := sink.Flush(context.Background()); err != nil {
+			o.logger.Warnf("sink flush error: %v", err)
+		}
+	}
+
+	if o.remoteWriter != nil {
+		families, err := o.registry.Gather()
+		if err != nil {
+			o.logger.Warnf("remote write gather error: %v", err)
+
+			return
+		}
+
+		o.remoteWriter.Enqueue(families, time.Now())
+	}
 }
 
 func (o *Output) Stop() error {
 	defer close(o.sampleChannel)
 
 	o.flusher.Stop()
+	o.tsdb.Close()
+
+	for _, sink := range o.sinks {
+		if err := sink.Close(); err != nil {
+			o.logger.Warnf("sink close error: %v", err)
+		}
+	}
+
+	if o.remoteWriter != nil {
+		o.remoteWriter.Close()
+	}
+
 	o.wg.Wait()
 
 	opts, err := getopts(o.arg)
@@ -269,3 +436,85 @@ func (o *Output) Stop() error {
 
 //go:embed index.html
 var index string
+
+// splitCSV splits a comma-separated output URL parameter into its parts,
+// returning nil for an empty string so callers can tell "not set" apart
+// from "set to an empty list".
+func splitCSV(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, ",")
+}
+
+// loadBucketOverrides builds the per-metric histogram bucket overrides from
+// the ?buckets_config=./file.json output URL parameter and/or the inline
+// ?buckets=... parameter, with the inline parameter taking precedence.
+func loadBucketOverrides(opts *options) (map[string][]float64, error) {
+	overrides := map[string][]float64{}
+
+	if opts.BucketsConfig != "" {
+		data, err := os.ReadFile(opts.BucketsConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(data, &overrides); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, buckets := range parseBucketsParam(opts.Buckets) {
+		overrides[name] = buckets
+	}
+
+	return overrides, nil
+}
+
+// parseBucketsParam parses a "metric:b1|b2|b3,metric2:b1|b2" output URL
+// parameter into a bucket override map, skipping malformed entries.
+func parseBucketsParam(s string) map[string][]float64 {
+	overrides := map[string][]float64{}
+
+	for _, entry := range splitCSV(s) {
+		name, raw, ok := strings.Cut(entry, ":")
+		if !ok {
+			continue
+		}
+
+		var buckets []float64
+
+		for _, v := range strings.Split(raw, "|") {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+
+			buckets = append(buckets, f)
+		}
+
+		if len(buckets) > 0 {
+			overrides[name] = buckets
+		}
+	}
+
+	return overrides
+}
+
+// parseLabels parses a comma-separated "key=value,key=value" output URL
+// parameter into a label map, skipping malformed entries.
+func parseLabels(s string) map[string]string {
+	labels := map[string]string{}
+
+	for _, kv := range splitCSV(s) {
+		parts := strings.SplitN(kv, "=", 2) // nolint:gomnd
+		if len(parts) != 2 {
+			continue
+		}
+
+		labels[parts[0]] = parts[1]
+	}
+
+	return labels
+}
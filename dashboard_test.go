@@ -0,0 +1,88 @@
+// MIT License
+//
+// Copyright (c) 2021 Iván Szkiba
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dashboard
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethinx/xk6-dashboard/internal"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.k6.io/k6/metrics"
+)
+
+// delayedContainer simulates a slow sample batch: GetSamples blocks for
+// delay before returning, so the test below can tell whether Wait returned
+// before or after MetricWorker's spawned goroutine actually finished.
+type delayedContainer struct {
+	delay time.Duration
+	done  *int32
+}
+
+func (d delayedContainer) GetSamples() []metrics.Sample {
+	time.Sleep(d.delay)
+	atomic.AddInt32(d.done, 1)
+
+	return nil
+}
+
+// TestMetricWorkerWaitsForInFlightWork guards the chunk0-4 fix: wg.Add must
+// happen in MetricWorker's loop before the "go" statement it pairs with, not
+// inside the spawned goroutine, otherwise wg.Wait() (as called from Stop)
+// can return while sample groups are still being processed.
+func TestMetricWorkerWaitsForInFlightWork(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	o := &Output{ // nolint:exhaustivestruct
+		PrometheusAdapter: internal.NewPrometheusAdapter(registry, logrus.New(), "", "", nil, false),
+		selfMetrics:       internal.NewSelfMetrics(registry),
+		sampleChannel:     make(chan []metrics.SampleContainer, 10),
+	}
+
+	const groups = 5
+
+	var done int32
+
+	workerDone := make(chan struct{})
+
+	go func() {
+		o.MetricWorker()
+		close(workerDone)
+	}()
+
+	for i := 0; i < groups; i++ {
+		o.sampleChannel <- []metrics.SampleContainer{delayedContainer{delay: 20 * time.Millisecond, done: &done}}
+	}
+
+	close(o.sampleChannel)
+
+	// Let MetricWorker's loop drain the channel first, so Wait only races
+	// against the goroutines it spawned, not against the loop itself.
+	<-workerDone
+	o.wg.Wait()
+
+	if got := atomic.LoadInt32(&done); got != groups {
+		t.Fatalf("expected all %d in-flight sample groups to finish before Wait returned, got %d", groups, got)
+	}
+}
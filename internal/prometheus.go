@@ -23,8 +23,11 @@
 package internal
 
 import (
-	// "fmt"
+	"context"
+	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -35,13 +38,87 @@ import (
 
 type PrometheusAdapter struct {
 	// metrics   map[string]interface{}
-	Subsystem      string
-	Namespace      string
-	logger         logrus.FieldLogger
-	metrics        sync.Map
-	registry       *prometheus.Registry
-	lock           sync.RWMutex
-	builtinMetrics builtinMetrics
+	Subsystem        string
+	Namespace        string
+	logger           logrus.FieldLogger
+	metrics          sync.Map
+	registry         *prometheus.Registry
+	lock             sync.RWMutex
+	builtinMetrics   builtinMetrics
+	tags             tagFilter
+	bucketOverrides  map[string][]float64
+	nativeHistograms bool
+
+	// customSignatures locks the label name set of a custom metric to
+	// whichever tag set its first sample had, keyed by "kind:name". k6
+	// samples for the same metric don't always carry the same tags (e.g. a
+	// check's tags differ from a request's), and registering a second
+	// CollectorVec under the same name with different label dimensions is
+	// a registry conflict, not an AlreadyRegisteredError. Locking the
+	// signature means later samples reuse it (see resolveCustomNames)
+	// instead of retrying a doomed registration on every single sample.
+	customSignatures sync.Map
+	customWarned     sync.Map
+}
+
+const (
+	// defaultNativeHistogramFactor is the bucket growth factor used when
+	// native (sparse) histograms are enabled, a commonly recommended
+	// trade-off between resolution and memory use.
+	defaultNativeHistogramFactor     = 1.1
+	defaultNativeHistogramMaxBuckets = 100
+)
+
+// tagFilter decides which sample tags are turned into Prometheus labels for
+// dynamically registered custom metrics. An empty allow list means "allow
+// everything not explicitly denied", which keeps the default behavior
+// unrestricted while still letting users cap cardinality via the output URL.
+type tagFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func newTagFilter(allow, deny []string) tagFilter {
+	f := tagFilter{allow: make(map[string]bool), deny: make(map[string]bool)}
+
+	for _, t := range allow {
+		f.allow[t] = true
+	}
+
+	for _, t := range deny {
+		f.deny[t] = true
+	}
+
+	return f
+}
+
+// names returns the tag keys that survive the filter, sorted so the same
+// tag set always yields the same label signature.
+func (f tagFilter) names(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+
+	for k := range tags {
+		if len(f.allow) > 0 && !f.allow[k] {
+			continue
+		}
+
+		if f.deny[k] {
+			continue
+		}
+
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// SetTagFilter configures the allow/deny list used to turn sample tags into
+// Prometheus labels for custom metrics, mirroring the output URL's
+// ?tags=...&drop_tags=... parameters.
+func (a *PrometheusAdapter) SetTagFilter(allow, deny []string) {
+	a.tags = newTagFilter(allow, deny)
 }
 
 var builtinMetricsMap = map[string]string{
@@ -148,22 +225,45 @@ type Histogram struct {
 	Help      string
 }
 
-func NewHistogram(registry *prometheus.Registry, namespace, subsystem, name, help string, buckets []float64) prometheus.Histogram {
-	if len(buckets) == 0 {
-		buckets = append([]float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}, prometheus.ExponentialBuckets(1, 2, 16)...)
-	}
-	metric := prometheus.NewHistogram(prometheus.HistogramOpts{ // nolint:exhaustivestruct
+// NewHistogram creates a Prometheus histogram for name. If native is true it
+// is configured as a native (sparse) histogram instead of using fixed
+// buckets, ignoring the buckets argument. Otherwise, buckets is used if
+// non-empty, falling back to the repo-wide default bucket layout.
+func NewHistogram(registry *prometheus.Registry, namespace, subsystem, name, help string, buckets []float64, native bool) prometheus.Histogram {
+	opts := prometheus.HistogramOpts{ // nolint:exhaustivestruct
 		Namespace: namespace,
 		Subsystem: subsystem,
 		Name:      name,
 		Help:      help,
-		Buckets:   buckets,
-	})
+	}
 
-	return metric
+	if native {
+		opts.NativeHistogramBucketFactor = defaultNativeHistogramFactor
+		opts.NativeHistogramMaxBucketNumber = defaultNativeHistogramMaxBuckets
+	} else {
+		if len(buckets) == 0 {
+			buckets = append([]float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}, prometheus.ExponentialBuckets(1, 2, 16)...)
+		}
+
+		opts.Buckets = buckets
+	}
+
+	return prometheus.NewHistogram(opts)
 }
 
-func NewPrometheusAdapter(registry *prometheus.Registry, logger logrus.FieldLogger, ns, sub string) *PrometheusAdapter {
+// NewPrometheusAdapter creates a PrometheusAdapter and registers its builtin
+// metrics on registry. bucketOverrides, keyed by k6 metric name, overrides
+// the default bucket layout of individual builtin and custom histograms;
+// nativeHistograms switches every histogram to Prometheus native (sparse)
+// histograms instead, ignoring bucketOverrides.
+func NewPrometheusAdapter(
+	registry *prometheus.Registry, logger logrus.FieldLogger, ns, sub string,
+	bucketOverrides map[string][]float64, nativeHistograms bool,
+) *PrometheusAdapter {
+	histogram := func(name, help string) prometheus.Histogram {
+		return NewHistogram(registry, ns, sub, name, help, bucketOverrides[name], nativeHistograms)
+	}
+
 	builtinMetrics := builtinMetrics{
 		VUS:                          NewGauge(registry, ns, sub, "vus", "Current number of active virtual users"),
 		VUSMax:                       NewGauge(registry, ns, sub, "vus_max", "Max possible number of virtual users"),
@@ -180,16 +280,16 @@ func NewPrometheusAdapter(registry *prometheus.Registry, logger logrus.FieldLogg
 		HTTPReqs:                     NewCounter(registry, ns, sub, "http_reqs", "How many HTTP requests has k6 generated, in total"),
 		Iterations:                   NewCounter(registry, ns, sub, "iterations", "The aggregate number of times the VUs in the test have executed"),
 		DroppedIterations:            NewCounter(registry, ns, sub, "dropped_iterations", "The number of iterations that could not be started"),
-		HTTPReqBlocked:               NewHistogram(registry, ns, sub, "http_req_blocked", "time spent blocked  before initiating the request", []float64{}),
-		HTTPReqConnecting:            NewHistogram(registry, ns, sub, "http_req_connecting", "time spent establishing tcp connection", []float64{}),
-		HTTPReqReceiving:             NewHistogram(registry, ns, sub, "http_req_receiving", "time spent receiving response data", []float64{}),
-		HTTPReqSending:               NewHistogram(registry, ns, sub, "http_req_sending", "time spent sending data", []float64{}),
-		HTTPReqTLSHandshaking:        NewHistogram(registry, ns, sub, "http_req_tls_handshaking", "time spent handshaking tls session", []float64{}),
-		HTTPReqWaiting:               NewHistogram(registry, ns, sub, "http_req_waiting", "time spent waiting for response", []float64{}),
-		HTTPReqDuration:              NewHistogram(registry, ns, sub, "http_req_duration", "total time for the request", []float64{}),
-		IterationDuration:            NewHistogram(registry, ns, sub, "iteration_duration", "the time it took to complete one full iteration", []float64{}),
-		Checks:                       NewHistogram(registry, ns, sub, "checks", "The rate of successful checks", []float64{}),
-		HTTPReqFailed:                NewHistogram(registry, ns, sub, "http_req_failed", "The rate of failed requests", []float64{}),
+		HTTPReqBlocked:               histogram("http_req_blocked", "time spent blocked  before initiating the request"),
+		HTTPReqConnecting:            histogram("http_req_connecting", "time spent establishing tcp connection"),
+		HTTPReqReceiving:             histogram("http_req_receiving", "time spent receiving response data"),
+		HTTPReqSending:               histogram("http_req_sending", "time spent sending data"),
+		HTTPReqTLSHandshaking:        histogram("http_req_tls_handshaking", "time spent handshaking tls session"),
+		HTTPReqWaiting:               histogram("http_req_waiting", "time spent waiting for response"),
+		HTTPReqDuration:              histogram("http_req_duration", "total time for the request"),
+		IterationDuration:            histogram("iteration_duration", "the time it took to complete one full iteration"),
+		Checks:                       histogram("checks", "The rate of successful checks"),
+		HTTPReqFailed:                histogram("http_req_failed", "The rate of failed requests"),
 	}
 
 	// register builtin metrics
@@ -227,16 +327,39 @@ func NewPrometheusAdapter(registry *prometheus.Registry, logger logrus.FieldLogg
 	}
 
 	return &PrometheusAdapter{
-		Subsystem:      sub,
-		Namespace:      ns,
-		logger:         logger,
-		registry:       registry,
-		builtinMetrics: builtinMetrics,
+		Subsystem:        sub,
+		Namespace:        ns,
+		logger:           logger,
+		registry:         registry,
+		builtinMetrics:   builtinMetrics,
+		bucketOverrides:  bucketOverrides,
+		nativeHistograms: nativeHistograms,
 	}
 }
 
 func (a *PrometheusAdapter) Handler() http.Handler {
-	return promhttp.HandlerFor(a.registry, promhttp.HandlerOpts{}) // nolint:exhaustivestruct
+	opts := promhttp.HandlerOpts{} // nolint:exhaustivestruct
+
+	// Native histograms need the newer OpenMetrics exposition format to be
+	// visible to scrapers.
+	if a.nativeHistograms {
+		opts.EnableOpenMetrics = true
+	}
+
+	return promhttp.HandlerFor(a.registry, opts)
+}
+
+// Flush is a no-op: samples are already visible to scrapers as soon as
+// HandleSample updates the registered collectors. It exists so
+// PrometheusAdapter satisfies the Sink interface.
+func (a *PrometheusAdapter) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op: the registry has no resources to release. It exists so
+// PrometheusAdapter satisfies the Sink interface.
+func (a *PrometheusAdapter) Close() error {
+	return nil
 }
 
 func (a *PrometheusAdapter) HandleSample(sample *metrics.Sample) {
@@ -271,7 +394,7 @@ func (a *PrometheusAdapter) handleCounter(sample *metrics.Sample) {
 	case "iterations":
 		a.builtinMetrics.Iterations.Add(sample.Value)
 	default:
-		return
+		a.handleCustomCounter(sample)
 	}
 }
 
@@ -298,7 +421,7 @@ func (a *PrometheusAdapter) handleGauge(sample *metrics.Sample) {
 	case "iteration_duration_current":
 		a.builtinMetrics.IterationDurationCurrent.Set(sample.Value)
 	default:
-		return
+		a.handleCustomGauge(sample)
 	}
 }
 
@@ -309,7 +432,7 @@ func (a *PrometheusAdapter) handleRate(sample *metrics.Sample) {
 	case "http_req_failed":
 		a.builtinMetrics.HTTPReqFailed.Observe(sample.Value)
 	default:
-		return
+		a.handleCustomHistogram(sample)
 	}
 }
 
@@ -340,6 +463,208 @@ func (a *PrometheusAdapter) handleTrend(sample *metrics.Sample) {
 		a.builtinMetrics.IterationDurationCurrent.Set(sample.Value)
 		a.builtinMetrics.IterationDuration.Observe(sample.Value)
 	default:
+		a.handleCustomHistogram(sample)
+	}
+}
+
+// handleCustomCounter auto-registers a CounterVec for a user-defined k6
+// Counter metric on first sample, keyed by metric name plus the sorted set
+// of label names that survive the tag filter, and reuses it on later
+// samples.
+func (a *PrometheusAdapter) handleCustomCounter(sample *metrics.Sample) {
+	tags := sample.Tags.CloneTags()
+	names := a.resolveCustomNames("counter", sample.Metric.Name, a.tags.names(tags))
+
+	vec := a.customCounterVec(sample.Metric.Name, names)
+	if vec == nil {
 		return
 	}
+
+	vec.WithLabelValues(labelValues(tags, names)...).Add(sample.Value)
+}
+
+// handleCustomGauge auto-registers a GaugeVec for a user-defined k6 Gauge
+// metric on first sample.
+func (a *PrometheusAdapter) handleCustomGauge(sample *metrics.Sample) {
+	tags := sample.Tags.CloneTags()
+	names := a.resolveCustomNames("gauge", sample.Metric.Name, a.tags.names(tags))
+
+	vec := a.customGaugeVec(sample.Metric.Name, names)
+	if vec == nil {
+		return
+	}
+
+	vec.WithLabelValues(labelValues(tags, names)...).Set(sample.Value)
+}
+
+// handleCustomHistogram auto-registers a HistogramVec for a user-defined k6
+// Rate or Trend metric on first sample.
+func (a *PrometheusAdapter) handleCustomHistogram(sample *metrics.Sample) {
+	tags := sample.Tags.CloneTags()
+	names := a.resolveCustomNames("histogram", sample.Metric.Name, a.tags.names(tags))
+
+	vec := a.customHistogramVec(sample.Metric.Name, names)
+	if vec == nil {
+		return
+	}
+
+	vec.WithLabelValues(labelValues(tags, names)...).Observe(sample.Value)
+}
+
+// resolveCustomNames locks the label name set of a custom metric to
+// whichever tag set its first sample had, and returns that locked set for
+// every later sample regardless of which tags it actually carries -
+// customCounterVec/customGaugeVec/customHistogramVec key their cached vec
+// on this set, so reusing it is what lets samples with a differing tag set
+// (e.g. a request missing a "status" tag a later one has) land on the same
+// collector instead of each one trying, and failing, to register a new
+// collector under the same metric name. labelValues then pads any name
+// missing from a given sample's tags with "", and extra tags outside the
+// locked set are ignored. A metric's signature only changes a single
+// warning, not one per sample.
+func (a *PrometheusAdapter) resolveCustomNames(kind, name string, names []string) []string {
+	key := kind + ":" + name
+
+	actual, loaded := a.customSignatures.LoadOrStore(key, names)
+	locked := actual.([]string) // nolint:forcetypeassert
+
+	if loaded && !sameNames(locked, names) {
+		if _, warned := a.customWarned.LoadOrStore(key, struct{}{}); !warned {
+			a.logger.Warnf(
+				"custom metric %s: tag set changed (now %v, was %v), reusing the original label set for all future samples",
+				name, names, locked,
+			)
+		}
+	}
+
+	return locked
+}
+
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func labelValues(tags map[string]string, names []string) []string {
+	values := make([]string, len(names))
+
+	for i, name := range names {
+		values[i] = tags[name]
+	}
+
+	return values
+}
+
+func vecKey(kind, name string, names []string) string {
+	return kind + ":" + name + ":" + strings.Join(names, ",")
+}
+
+func (a *PrometheusAdapter) customCounterVec(name string, names []string) *prometheus.CounterVec {
+	key := vecKey("counter", name, names)
+
+	if v, ok := a.metrics.Load(key); ok {
+		return v.(*prometheus.CounterVec) // nolint:forcetypeassert
+	}
+
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{ // nolint:exhaustivestruct
+		Namespace: a.Namespace,
+		Subsystem: a.Subsystem,
+		Name:      name,
+		Help:      fmt.Sprintf("Custom k6 counter metric %s", name),
+	}, names)
+
+	if err := a.registry.Register(vec); err != nil {
+		are, ok := err.(prometheus.AlreadyRegisteredError) // nolint:errorlint
+		if !ok {
+			a.logger.Warnf("cannot register counter metric %s: %v", name, err)
+
+			return nil
+		}
+
+		vec = are.ExistingCollector.(*prometheus.CounterVec) // nolint:forcetypeassert
+	}
+
+	actual, _ := a.metrics.LoadOrStore(key, vec)
+
+	return actual.(*prometheus.CounterVec) // nolint:forcetypeassert
+}
+
+func (a *PrometheusAdapter) customGaugeVec(name string, names []string) *prometheus.GaugeVec {
+	key := vecKey("gauge", name, names)
+
+	if v, ok := a.metrics.Load(key); ok {
+		return v.(*prometheus.GaugeVec) // nolint:forcetypeassert
+	}
+
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{ // nolint:exhaustivestruct
+		Namespace: a.Namespace,
+		Subsystem: a.Subsystem,
+		Name:      name,
+		Help:      fmt.Sprintf("Custom k6 gauge metric %s", name),
+	}, names)
+
+	if err := a.registry.Register(vec); err != nil {
+		are, ok := err.(prometheus.AlreadyRegisteredError) // nolint:errorlint
+		if !ok {
+			a.logger.Warnf("cannot register gauge metric %s: %v", name, err)
+
+			return nil
+		}
+
+		vec = are.ExistingCollector.(*prometheus.GaugeVec) // nolint:forcetypeassert
+	}
+
+	actual, _ := a.metrics.LoadOrStore(key, vec)
+
+	return actual.(*prometheus.GaugeVec) // nolint:forcetypeassert
+}
+
+func (a *PrometheusAdapter) customHistogramVec(name string, names []string) *prometheus.HistogramVec {
+	key := vecKey("histogram", name, names)
+
+	if v, ok := a.metrics.Load(key); ok {
+		return v.(*prometheus.HistogramVec) // nolint:forcetypeassert
+	}
+
+	opts := prometheus.HistogramOpts{ // nolint:exhaustivestruct
+		Namespace: a.Namespace,
+		Subsystem: a.Subsystem,
+		Name:      name,
+		Help:      fmt.Sprintf("Custom k6 trend/rate metric %s", name),
+	}
+
+	if a.nativeHistograms {
+		opts.NativeHistogramBucketFactor = defaultNativeHistogramFactor
+		opts.NativeHistogramMaxBucketNumber = defaultNativeHistogramMaxBuckets
+	} else if buckets, ok := a.bucketOverrides[name]; ok {
+		opts.Buckets = buckets
+	} else {
+		opts.Buckets = append([]float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}, prometheus.ExponentialBuckets(1, 2, 16)...)
+	}
+
+	vec := prometheus.NewHistogramVec(opts, names)
+
+	if err := a.registry.Register(vec); err != nil {
+		are, ok := err.(prometheus.AlreadyRegisteredError) // nolint:errorlint
+		if !ok {
+			a.logger.Warnf("cannot register histogram metric %s: %v", name, err)
+
+			return nil
+		}
+
+		vec = are.ExistingCollector.(*prometheus.HistogramVec) // nolint:forcetypeassert
+	}
+
+	actual, _ := a.metrics.LoadOrStore(key, vec)
+
+	return actual.(*prometheus.HistogramVec) // nolint:forcetypeassert
 }
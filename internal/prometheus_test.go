@@ -0,0 +1,88 @@
+// MIT License
+//
+// Copyright (c) 2021 Iván Szkiba
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestTagFilterNames(t *testing.T) {
+	tags := map[string]string{"method": "GET", "status": "200", "url": "/x"}
+
+	t.Run("allow all not denied", func(t *testing.T) {
+		f := newTagFilter(nil, nil)
+		if got := f.names(tags); len(got) != 3 {
+			t.Fatalf("expected all 3 tags, got %v", got)
+		}
+	})
+
+	t.Run("allow list restricts", func(t *testing.T) {
+		f := newTagFilter([]string{"method"}, nil)
+		if got := f.names(tags); len(got) != 1 || got[0] != "method" {
+			t.Fatalf("expected only [method], got %v", got)
+		}
+	})
+
+	t.Run("deny list excludes", func(t *testing.T) {
+		f := newTagFilter(nil, []string{"url"})
+		if got := f.names(tags); len(got) != 2 {
+			t.Fatalf("expected 2 tags with url denied, got %v", got)
+		}
+	})
+
+	t.Run("sorted", func(t *testing.T) {
+		f := newTagFilter(nil, nil)
+		got := f.names(tags)
+
+		for i := 1; i < len(got); i++ {
+			if got[i-1] > got[i] {
+				t.Fatalf("expected sorted names, got %v", got)
+			}
+		}
+	})
+}
+
+func TestResolveCustomNamesLocksFirstSignature(t *testing.T) {
+	a := &PrometheusAdapter{logger: logrus.New()} // nolint:exhaustivestruct
+
+	first := a.resolveCustomNames("counter", "my_metric", []string{"method", "status"})
+	if !sameNames(first, []string{"method", "status"}) {
+		t.Fatalf("expected first signature to be returned as-is, got %v", first)
+	}
+
+	// A later sample with a different tag set reuses the locked signature
+	// instead of returning its own, so every sample for this metric keeps
+	// landing on the same cached vec.
+	second := a.resolveCustomNames("counter", "my_metric", []string{"method"})
+	if !sameNames(second, []string{"method", "status"}) {
+		t.Fatalf("expected locked signature to be reused, got %v", second)
+	}
+
+	// A different metric name/kind isn't affected by the lock above.
+	other := a.resolveCustomNames("gauge", "my_metric", []string{"url"})
+	if !sameNames(other, []string{"url"}) {
+		t.Fatalf("expected independent signature for a different kind, got %v", other)
+	}
+}
@@ -0,0 +1,244 @@
+// MIT License
+//
+// Copyright (c) 2021 Iván Szkiba
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	remoteWriteVersion   = "0.1.0"
+	remoteWriteQueueSize = 256
+	remoteWriteMaxRetry  = 5
+	remoteWriteBaseDelay = 500 * time.Millisecond
+)
+
+// RemoteWriter snapshots a prometheus.Registry after every flush period and
+// pushes the samples to an external Prometheus Remote Write endpoint, so a
+// long-running k6 test can be pushed into Thanos/Mimir/Cortex without
+// standing up a Prometheus scraper next to k6.
+type RemoteWriter struct {
+	url    string
+	bearer string
+	labels map[string]string
+	logger logrus.FieldLogger
+	client *http.Client
+
+	queue   chan []prompb.TimeSeries
+	dropped prometheus.Counter
+
+	wg   sync.WaitGroup
+	stop chan struct{}
+}
+
+// NewRemoteWriter creates a RemoteWriter that posts to url, optionally using
+// a bearer token, and attaching the given extra labels (e.g. env, job) to
+// every time series. dropped, if non-nil, is incremented by the number of
+// samples in any batch discarded because the delivery queue is full. The
+// returned writer owns a background goroutine; call Close to stop it.
+func NewRemoteWriter(url, bearer string, labels map[string]string, logger logrus.FieldLogger, dropped prometheus.Counter) *RemoteWriter {
+	w := &RemoteWriter{
+		url:     url,
+		bearer:  bearer,
+		labels:  labels,
+		logger:  logger,
+		client:  &http.Client{Timeout: 10 * time.Second}, // nolint:gomnd
+		queue:   make(chan []prompb.TimeSeries, remoteWriteQueueSize),
+		dropped: dropped,
+		stop:    make(chan struct{}),
+	}
+
+	w.wg.Add(1)
+
+	go w.loop()
+
+	return w
+}
+
+func (w *RemoteWriter) loop() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case batch := <-w.queue:
+			w.send(batch)
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Enqueue converts a registry snapshot into Remote Write time series and
+// queues it for delivery. If the bounded queue is full the batch is dropped,
+// with a warning, so a slow or unreachable endpoint cannot create unbounded
+// memory growth.
+func (w *RemoteWriter) Enqueue(families []*dto.MetricFamily, ts time.Time) {
+	batch := toTimeSeries(families, ts, w.labels)
+	if len(batch) == 0 {
+		return
+	}
+
+	select {
+	case w.queue <- batch:
+	default:
+		w.logger.Warn("remote write queue full, dropping batch")
+
+		if w.dropped != nil {
+			w.dropped.Add(float64(len(batch)))
+		}
+	}
+}
+
+// Close stops the background delivery goroutine, waiting for the
+// in-progress send (if any) to finish.
+func (w *RemoteWriter) Close() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+// toTimeSeries converts a registry snapshot into Remote Write time series.
+// It expands each gathered metric the same way the TSDB does (see
+// expandMetric): a Histogram or Summary becomes several labeled series
+// (_bucket{le=...}/_sum/_count, or {quantile=...}/_sum/_count) rather than
+// a single series carrying just the cumulative sum, so downstream systems
+// like Thanos/Mimir/Grafana can reconstruct the distribution.
+func toTimeSeries(families []*dto.MetricFamily, ts time.Time, extra map[string]string) []prompb.TimeSeries {
+	timestamp := ts.UnixNano() / int64(time.Millisecond)
+	series := make([]prompb.TimeSeries, 0, len(families))
+
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			for _, es := range expandMetric(mf, m) {
+				labels := make(map[string]string, len(es.labels)+len(extra)+1)
+
+				for k, val := range extra {
+					labels[k] = val
+				}
+
+				for k, val := range es.labels {
+					labels[k] = val
+				}
+
+				labels["__name__"] = es.name
+
+				series = append(series, prompb.TimeSeries{
+					Labels:  toLabels(labels),
+					Samples: []prompb.Sample{{Value: es.value, Timestamp: timestamp}},
+				})
+			}
+		}
+	}
+
+	return series
+}
+
+func toLabels(labels map[string]string) []prompb.Label {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	pbLabels := make([]prompb.Label, 0, len(names))
+	for _, k := range names {
+		pbLabels = append(pbLabels, prompb.Label{Name: k, Value: labels[k]})
+	}
+
+	return pbLabels
+}
+
+func (w *RemoteWriter) send(batch []prompb.TimeSeries) {
+	req := &prompb.WriteRequest{Timeseries: batch}
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		w.logger.Warnf("remote write marshal error: %v", err)
+
+		return
+	}
+
+	compressed := snappy.Encode(nil, data)
+	delay := remoteWriteBaseDelay
+
+	for attempt := 0; attempt <= remoteWriteMaxRetry; attempt++ {
+		if w.post(compressed) {
+			return
+		}
+
+		if attempt == remoteWriteMaxRetry {
+			w.logger.Warn("remote write failed, giving up on batch")
+
+			return
+		}
+
+		time.Sleep(delay)
+
+		delay *= 2
+	}
+}
+
+func (w *RemoteWriter) post(body []byte) bool {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		w.logger.Warnf("remote write request error: %v", err)
+
+		return false
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", remoteWriteVersion)
+
+	if w.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+w.bearer)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		w.logger.Warnf("remote write post error: %v", err)
+
+		return false
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode/100 != 2 {
+		w.logger.Warnf("remote write endpoint returned %d", resp.StatusCode)
+
+		return false
+	}
+
+	return true
+}
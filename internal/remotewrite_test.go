@@ -0,0 +1,74 @@
+// MIT License
+//
+// Copyright (c) 2021 Iván Szkiba
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/sirupsen/logrus"
+)
+
+// TestRemoteWriterEnqueueDropsIncrementCounter calls the real Enqueue
+// against an unbuffered queue (so its send always falls through to the
+// queue-full branch) and checks that it increments the supplied dropped
+// counter by the number of time series in the dropped batch, rather than
+// silently discarding them.
+func TestRemoteWriterEnqueueDropsIncrementCounter(t *testing.T) {
+	dropped := prometheus.NewCounter(prometheus.CounterOpts{Name: "dropped"}) // nolint:exhaustivestruct
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "my_counter"}) // nolint:exhaustivestruct
+	counter.Add(1)
+
+	if err := registry.Register(counter); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+
+	w := &RemoteWriter{ // nolint:exhaustivestruct
+		logger:  logrus.New(),
+		queue:   make(chan []prompb.TimeSeries), // unbuffered: Enqueue's send always falls through to default
+		dropped: dropped,
+	}
+
+	w.Enqueue(families, time.Now())
+
+	var m dto.Metric
+
+	if err := dropped.Write(&m); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// One counter sample expands to exactly one time series (see expandMetric).
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Fatalf("expected dropped counter at 1, got %v", got)
+	}
+}
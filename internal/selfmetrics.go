@@ -0,0 +1,123 @@
+// MIT License
+//
+// Copyright (c) 2021 Iván Szkiba
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const selfMetricsNamespace = "xk6_dashboard"
+
+// SelfMetrics exposes the health of the dashboard output itself - its
+// worker pool, sample pipeline and HTTP server - on the same registry as
+// the k6 metrics it scrapes, so a stalled flusher or a leaking goroutine
+// pool is visible without reading logs.
+type SelfMetrics struct {
+	WorkerGoroutines *prometheus.GaugeVec
+	SamplesProcessed *prometheus.CounterVec
+	SamplesDropped   prometheus.Counter
+	FlushDuration    prometheus.Histogram
+	SampleBatchSize  prometheus.Histogram
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	httpInFlight prometheus.Gauge
+}
+
+// NewSelfMetrics creates and registers the self-observability collectors on
+// registry.
+func NewSelfMetrics(registry *prometheus.Registry) *SelfMetrics {
+	m := &SelfMetrics{
+		WorkerGoroutines: prometheus.NewGaugeVec(prometheus.GaugeOpts{ // nolint:exhaustivestruct
+			Namespace: selfMetricsNamespace,
+			Name:      "worker_goroutines",
+			Help:      "Number of active sample processing goroutines",
+		}, []string{"pool"}),
+		SamplesProcessed: prometheus.NewCounterVec(prometheus.CounterOpts{ // nolint:exhaustivestruct
+			Namespace: selfMetricsNamespace,
+			Name:      "samples_processed_total",
+			Help:      "Total number of k6 samples processed, by metric type",
+		}, []string{"type"}),
+		SamplesDropped: prometheus.NewCounter(prometheus.CounterOpts{ // nolint:exhaustivestruct
+			Namespace: selfMetricsNamespace,
+			Name:      "samples_dropped_total",
+			Help:      "Total number of Remote Write samples dropped because the delivery queue was full",
+		}),
+		FlushDuration: prometheus.NewHistogram(prometheus.HistogramOpts{ // nolint:exhaustivestruct
+			Namespace: selfMetricsNamespace,
+			Name:      "flush_duration_seconds",
+			Help:      "Time spent processing one flushed batch of samples",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		SampleBatchSize: prometheus.NewHistogram(prometheus.HistogramOpts{ // nolint:exhaustivestruct
+			Namespace: selfMetricsNamespace,
+			Name:      "sample_batch_size",
+			Help:      "Number of samples contained in one flushed batch",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 16),
+		}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{ // nolint:exhaustivestruct
+			Namespace: selfMetricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Total number of HTTP requests served by the dashboard",
+		}, []string{"code", "method"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{ // nolint:exhaustivestruct
+			Namespace: selfMetricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests served by the dashboard",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"code", "method"}),
+		httpInFlight: prometheus.NewGauge(prometheus.GaugeOpts{ // nolint:exhaustivestruct
+			Namespace: selfMetricsNamespace,
+			Name:      "http_requests_in_flight",
+			Help:      "Number of HTTP requests currently being served by the dashboard",
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		m.WorkerGoroutines, m.SamplesProcessed, m.SamplesDropped,
+		m.FlushDuration, m.SampleBatchSize,
+		m.httpRequests, m.httpDuration, m.httpInFlight,
+	} {
+		registry.Register(c) // nolint:errcheck
+	}
+
+	return m
+}
+
+// ObserveFlush records the duration and size of one processed sample batch.
+func (m *SelfMetrics) ObserveFlush(d time.Duration, size int) {
+	m.FlushDuration.Observe(d.Seconds())
+	m.SampleBatchSize.Observe(float64(size))
+}
+
+// Instrument wraps handler with the standard promhttp request counter,
+// duration and in-flight instrumentation, labeled by "code" and "method".
+func (m *SelfMetrics) Instrument(handler http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerInFlight(m.httpInFlight,
+		promhttp.InstrumentHandlerDuration(m.httpDuration,
+			promhttp.InstrumentHandlerCounter(m.httpRequests, handler)))
+}
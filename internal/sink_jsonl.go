@@ -0,0 +1,94 @@
+// MIT License
+//
+// Copyright (c) 2021 Iván Szkiba
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.k6.io/k6/metrics"
+)
+
+// JSONLSink writes one JSON object per sample to a file, for offline
+// analysis without running a metrics backend.
+type JSONLSink struct {
+	logger logrus.FieldLogger
+
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+type jsonlRecord struct {
+	Time  time.Time         `json:"time"`
+	Name  string            `json:"name"`
+	Type  string            `json:"type"`
+	Value float64           `json:"value"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+// NewJSONLSink opens (creating if necessary) path for appending and returns
+// a Sink that writes one line per sample to it.
+func NewJSONLSink(path string, logger logrus.FieldLogger) (*JSONLSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644) // nolint:gomnd
+	if err != nil {
+		return nil, err
+	}
+
+	return &JSONLSink{logger: logger, file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (s *JSONLSink) HandleSample(sample *metrics.Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := jsonlRecord{
+		Time:  sample.Time,
+		Name:  sample.Metric.Name,
+		Type:  sample.Metric.Type.String(),
+		Value: sample.Value,
+		Tags:  sample.Tags.CloneTags(),
+	}
+
+	if err := s.enc.Encode(record); err != nil {
+		s.logger.Warnf("jsonl sink write error: %v", err)
+	}
+}
+
+func (s *JSONLSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Sync()
+}
+
+func (s *JSONLSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,237 @@
+// MIT License
+//
+// Copyright (c) 2021 Iván Szkiba
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.k6.io/k6/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// otlpBuckets mirrors the bucket bounds NewHistogram uses by default, so a
+// Trend metric looks the same regardless of which sink receives it.
+var otlpBuckets = append([]float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}, prometheus.ExponentialBuckets(1, 2, 16)...) // nolint:gochecknoglobals
+
+type otlpKind int
+
+const (
+	otlpSum otlpKind = iota
+	otlpGauge
+	otlpHistogram
+)
+
+type otlpPoint struct {
+	kind   otlpKind
+	attrs  attribute.Set
+	sum    float64
+	count  uint64
+	counts []uint64
+}
+
+// OTLPSink maps k6 metrics onto OpenTelemetry instruments and periodically
+// exports them over OTLP/gRPC: Counter->Sum, Gauge->Gauge,
+// Trend->Histogram (using otlpBuckets) and Rate->Gauge.
+type OTLPSink struct {
+	logger   logrus.FieldLogger
+	exporter *otlpmetricgrpc.Exporter
+
+	mu     sync.Mutex
+	points map[string]map[attribute.Distinct]*otlpPoint
+}
+
+// NewOTLPSink dials the OTLP/gRPC collector at endpoint and returns a Sink
+// that exports accumulated samples on every Flush.
+func NewOTLPSink(ctx context.Context, endpoint string, logger logrus.FieldLogger) (*OTLPSink, error) {
+	exporter, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithEndpoint(endpoint), otlpmetricgrpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+
+	return &OTLPSink{
+		logger:   logger,
+		exporter: exporter,
+		points:   make(map[string]map[attribute.Distinct]*otlpPoint),
+	}, nil
+}
+
+func (s *OTLPSink) HandleSample(sample *metrics.Sample) {
+	kind := otlpGauge
+
+	switch sample.Metric.Type {
+	case metrics.Counter:
+		kind = otlpSum
+	case metrics.Trend:
+		kind = otlpHistogram
+	case metrics.Gauge, metrics.Rate:
+		kind = otlpGauge
+	}
+
+	attrs := tagsToAttributes(sample.Tags.CloneTags())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byAttr, ok := s.points[sample.Metric.Name]
+	if !ok {
+		byAttr = make(map[attribute.Distinct]*otlpPoint)
+		s.points[sample.Metric.Name] = byAttr
+	}
+
+	p, ok := byAttr[attrs.Equivalent()]
+	if !ok {
+		p = &otlpPoint{kind: kind, attrs: attrs, counts: make([]uint64, len(otlpBuckets)+1)}
+		byAttr[attrs.Equivalent()] = p
+	}
+
+	switch kind {
+	case otlpSum:
+		p.sum += sample.Value
+	case otlpGauge:
+		p.sum = sample.Value
+	case otlpHistogram:
+		p.sum += sample.Value
+		p.count++
+		p.counts[bucketIndex(sample.Value)]++
+	}
+}
+
+// bucketIndex returns the single otlpBuckets slot value falls into, per the
+// OTel metrics data model: BucketCounts are exclusive per-bucket counts
+// (sum(BucketCounts) == Count), unlike Prometheus's cumulative buckets.
+// value falls in bucket i when it's <= otlpBuckets[i] and > every earlier
+// bound; if it exceeds the last bound it goes in the overflow bucket at
+// index len(otlpBuckets).
+func bucketIndex(value float64) int {
+	for i, bound := range otlpBuckets {
+		if value <= bound {
+			return i
+		}
+	}
+
+	return len(otlpBuckets)
+}
+
+func tagsToAttributes(tags map[string]string) attribute.Set {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	kvs := make([]attribute.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, attribute.String(k, tags[k]))
+	}
+
+	return attribute.NewSet(kvs...)
+}
+
+// Flush exports the current value of every instrument.
+func (s *OTLPSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	rm := s.snapshot()
+	s.mu.Unlock()
+
+	return s.exporter.Export(ctx, rm)
+}
+
+func (s *OTLPSink) snapshot() *metricdata.ResourceMetrics {
+	now := time.Now()
+	out := make([]metricdata.Metrics, 0, len(s.points))
+
+	for name, byAttr := range s.points {
+		if len(byAttr) == 0 {
+			continue
+		}
+
+		var kind otlpKind
+		for _, p := range byAttr {
+			kind = p.kind
+
+			break
+		}
+
+		out = append(out, metricsFor(name, kind, byAttr, now))
+	}
+
+	return &metricdata.ResourceMetrics{ScopeMetrics: []metricdata.ScopeMetrics{{Metrics: out}}}
+}
+
+func metricsFor(name string, kind otlpKind, byAttr map[attribute.Distinct]*otlpPoint, now time.Time) metricdata.Metrics {
+	switch kind {
+	case otlpSum:
+		dps := make([]metricdata.DataPoint[float64], 0, len(byAttr))
+		for _, p := range byAttr {
+			dps = append(dps, metricdata.DataPoint[float64]{Attributes: p.attrs, Time: now, Value: p.sum})
+		}
+
+		return metricdata.Metrics{
+			Name: name,
+			Data: metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true, DataPoints: dps},
+		}
+	case otlpHistogram:
+		dps := make([]metricdata.HistogramDataPoint[float64], 0, len(byAttr))
+		for _, p := range byAttr {
+			// p.counts is still live: HandleSample keeps mutating it under
+			// s.mu after Flush releases the lock and hands rm off to the
+			// async exporter, so it must be copied here, while the caller
+			// (snapshot, called from Flush) still holds the lock.
+			counts := make([]uint64, len(p.counts))
+			copy(counts, p.counts)
+
+			dps = append(dps, metricdata.HistogramDataPoint[float64]{
+				Attributes:   p.attrs,
+				Time:         now,
+				Bounds:       otlpBuckets,
+				BucketCounts: counts,
+				Count:        p.count,
+				Sum:          p.sum,
+			})
+		}
+
+		return metricdata.Metrics{
+			Name: name,
+			Data: metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality, DataPoints: dps},
+		}
+	default: // otlpGauge
+		dps := make([]metricdata.DataPoint[float64], 0, len(byAttr))
+		for _, p := range byAttr {
+			dps = append(dps, metricdata.DataPoint[float64]{Attributes: p.attrs, Time: now, Value: p.sum})
+		}
+
+		return metricdata.Metrics{Name: name, Data: metricdata.Gauge[float64]{DataPoints: dps}}
+	}
+}
+
+func (s *OTLPSink) Close() error {
+	return s.exporter.Shutdown(context.Background())
+}
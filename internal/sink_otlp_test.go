@@ -0,0 +1,96 @@
+// MIT License
+//
+// Copyright (c) 2021 Iván Szkiba
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestBucketIndexIsExclusive(t *testing.T) {
+	cases := []struct {
+		value float64
+		want  int
+	}{
+		{value: 0.05, want: 0},
+		{value: otlpBuckets[0], want: 0},
+		{value: otlpBuckets[0] + 0.01, want: 1},
+		{value: otlpBuckets[len(otlpBuckets)-1] + 1, want: len(otlpBuckets)},
+	}
+
+	for _, c := range cases {
+		if got := bucketIndex(c.value); got != c.want {
+			t.Fatalf("bucketIndex(%v) = %d, want %d", c.value, got, c.want)
+		}
+	}
+}
+
+// TestMetricsForHistogramBucketsAreExclusive guards against regressing to
+// Prometheus-style cumulative bucket counting: the OTel data model requires
+// sum(BucketCounts) == Count for every histogram data point. It accumulates
+// a multi-value series the same way HandleSample does (via bucketIndex) and
+// checks the metricsFor/snapshot output that's actually handed to the
+// exporter.
+func TestMetricsForHistogramBucketsAreExclusive(t *testing.T) {
+	values := []float64{0.05, 0.15, 1.5, 50, 0.05}
+
+	attrs := tagsToAttributes(map[string]string{})
+	p := &otlpPoint{kind: otlpHistogram, attrs: attrs, counts: make([]uint64, len(otlpBuckets)+1)} // nolint:exhaustivestruct
+
+	for _, v := range values {
+		p.sum += v
+		p.count++
+		p.counts[bucketIndex(v)]++
+	}
+
+	byAttr := map[attribute.Distinct]*otlpPoint{attrs.Equivalent(): p}
+
+	m := metricsFor("my_trend", otlpHistogram, byAttr, time.Now())
+
+	hist, ok := m.Data.(metricdata.Histogram[float64])
+	if !ok {
+		t.Fatalf("expected a Histogram, got %T", m.Data)
+	}
+
+	if len(hist.DataPoints) != 1 {
+		t.Fatalf("expected 1 data point, got %d", len(hist.DataPoints))
+	}
+
+	dp := hist.DataPoints[0]
+
+	var sum uint64
+	for _, c := range dp.BucketCounts {
+		sum += c
+	}
+
+	if sum != dp.Count {
+		t.Fatalf("sum(BucketCounts) = %d, want Count = %d (buckets must be exclusive, not cumulative)", sum, dp.Count)
+	}
+
+	if dp.Count != uint64(len(values)) {
+		t.Fatalf("expected Count = %d, got %d", len(values), dp.Count)
+	}
+}
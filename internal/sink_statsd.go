@@ -0,0 +1,106 @@
+// MIT License
+//
+// Copyright (c) 2021 Iván Szkiba
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.k6.io/k6/metrics"
+)
+
+// StatsDSink forwards samples to a StatsD/DogStatsD daemon over UDP, tagging
+// each line with the sample's k6 tags using the DogStatsD "|#k:v,k:v"
+// extension (widely supported by StatsD-compatible agents).
+type StatsDSink struct {
+	logger logrus.FieldLogger
+	conn   net.Conn
+}
+
+// NewStatsDSink dials addr (host:port) over UDP and returns a Sink that
+// writes one datagram per sample to it.
+func NewStatsDSink(addr string, logger logrus.FieldLogger) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsDSink{logger: logger, conn: conn}, nil
+}
+
+func (s *StatsDSink) HandleSample(sample *metrics.Sample) {
+	line := formatStatsD(sample)
+
+	if _, err := s.conn.Write([]byte(line)); err != nil {
+		s.logger.Warnf("statsd sink write error: %v", err)
+	}
+}
+
+func formatStatsD(sample *metrics.Sample) string {
+	kind := "g"
+
+	switch sample.Metric.Type {
+	case metrics.Counter:
+		kind = "c"
+	case metrics.Trend:
+		kind = "ms"
+	case metrics.Gauge, metrics.Rate:
+		kind = "g"
+	}
+
+	line := fmt.Sprintf("%s:%g|%s", sample.Metric.Name, sample.Value, kind)
+
+	if tags := joinTags(sample.Tags.CloneTags()); tags != "" {
+		line += "|#" + tags
+	}
+
+	return line
+}
+
+func joinTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + ":" + tags[k]
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (s *StatsDSink) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (s *StatsDSink) Close() error {
+	return s.conn.Close()
+}
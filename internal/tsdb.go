@@ -0,0 +1,386 @@
+// MIT License
+//
+// Copyright (c) 2021 Iván Szkiba
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultRetention = time.Hour
+
+type point struct {
+	t time.Time
+	v float64
+}
+
+type tsSeries struct {
+	name   string
+	labels map[string]string
+	points []point
+}
+
+// TSDB is a minimal in-process ring-buffer time series store. It
+// periodically scrapes a prometheus.Registry and serves a subset of the
+// Prometheus HTTP API (/api/v1/query, /api/v1/query_range,
+// /api/v1/label/__name__/values and /api/v1/series), so the embedded UI (or
+// Grafana pointed at the dashboard) can render trends over a whole test run
+// without any external storage.
+type TSDB struct {
+	registry  *prometheus.Registry
+	retention time.Duration
+
+	mu     sync.RWMutex
+	series map[string]*tsSeries
+
+	done chan struct{}
+}
+
+// NewTSDB creates a TSDB that scrapes registry. Call Run to start sampling
+// and Close to stop it.
+func NewTSDB(registry *prometheus.Registry) *TSDB {
+	return &TSDB{
+		registry:  registry,
+		retention: defaultRetention,
+		series:    make(map[string]*tsSeries),
+		done:      make(chan struct{}),
+	}
+}
+
+// Run scrapes the registry every period, keeping retention worth of samples,
+// until Close is called. Intended to run in its own goroutine.
+func (t *TSDB) Run(period, retention time.Duration) {
+	if retention > 0 {
+		t.retention = retention
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.scrape()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Close stops the scrape loop started by Run.
+func (t *TSDB) Close() {
+	close(t.done)
+}
+
+func seriesKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var b strings.Builder
+
+	b.WriteString(name)
+
+	for _, k := range keys {
+		b.WriteByte('\xff')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+	}
+
+	return b.String()
+}
+
+func (t *TSDB) scrape() {
+	families, err := t.registry.Gather()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			for _, es := range expandMetric(mf, m) {
+				key := seriesKey(es.name, es.labels)
+
+				s, found := t.series[key]
+				if !found {
+					s = &tsSeries{name: es.name, labels: es.labels}
+					t.series[key] = s
+				}
+
+				s.points = append(s.points, point{t: now, v: es.value})
+				s.points = pruneBefore(s.points, now.Add(-t.retention))
+			}
+		}
+	}
+}
+
+func pruneBefore(points []point, cutoff time.Time) []point {
+	i := 0
+	for i < len(points) && points[i].t.Before(cutoff) {
+		i++
+	}
+
+	return points[i:]
+}
+
+// expandedSample is one scalar time series produced by expanding a single
+// gathered metric: a Counter or Gauge expands to exactly one, while a
+// Histogram or Summary expands to several (one per bucket/quantile plus
+// _sum and _count), mirroring how Prometheus itself exposes them.
+type expandedSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// expandMetric turns one gathered dto.Metric into the scalar series it
+// represents. Counters and Gauges are already scalar. Histograms and
+// Summaries are cumulative aggregates, so returning just GetSampleSum
+// would render as an ever-growing line instead of a meaningful trend;
+// instead each bucket/quantile is expanded into its own labeled series,
+// the same way Prometheus's own text exposition format does it
+// (name_bucket{le=...}, name_sum, name_count, or name{quantile=...}).
+func expandMetric(mf *dto.MetricFamily, m *dto.Metric) []expandedSample {
+	name := mf.GetName()
+
+	labels := make(map[string]string, len(m.GetLabel()))
+	for _, lp := range m.GetLabel() {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		return []expandedSample{{name: name, labels: labels, value: m.GetCounter().GetValue()}}
+	case dto.MetricType_GAUGE:
+		return []expandedSample{{name: name, labels: labels, value: m.GetGauge().GetValue()}}
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		samples := make([]expandedSample, 0, len(h.GetBucket())+3) // nolint:gomnd
+
+		for _, b := range h.GetBucket() {
+			bucketLabels := withLabel(labels, "le", formatValue(b.GetUpperBound()))
+			samples = append(samples, expandedSample{name: name + "_bucket", labels: bucketLabels, value: float64(b.GetCumulativeCount())})
+		}
+
+		// The gathered buckets stop at the last finite bound; the text
+		// exposition format adds the implicit le="+Inf" bucket (= _count).
+		if n := len(h.GetBucket()); n == 0 || !math.IsInf(h.GetBucket()[n-1].GetUpperBound(), +1) {
+			infLabels := withLabel(labels, "le", formatValue(math.Inf(+1)))
+			samples = append(samples, expandedSample{name: name + "_bucket", labels: infLabels, value: float64(h.GetSampleCount())})
+		}
+
+		samples = append(samples,
+			expandedSample{name: name + "_sum", labels: labels, value: h.GetSampleSum()},
+			expandedSample{name: name + "_count", labels: labels, value: float64(h.GetSampleCount())},
+		)
+
+		return samples
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		samples := make([]expandedSample, 0, len(s.GetQuantile())+2) // nolint:gomnd
+
+		for _, q := range s.GetQuantile() {
+			quantileLabels := withLabel(labels, "quantile", formatValue(q.GetQuantile()))
+			samples = append(samples, expandedSample{name: name, labels: quantileLabels, value: q.GetValue()})
+		}
+
+		samples = append(samples,
+			expandedSample{name: name + "_sum", labels: labels, value: s.GetSampleSum()},
+			expandedSample{name: name + "_count", labels: labels, value: float64(s.GetSampleCount())},
+		)
+
+		return samples
+	default:
+		return nil
+	}
+}
+
+// withLabel returns a copy of labels with k=v added, leaving labels itself
+// untouched so it can still be reused for the next expanded sample.
+func withLabel(labels map[string]string, k, v string) map[string]string {
+	out := make(map[string]string, len(labels)+1)
+	for lk, lv := range labels {
+		out[lk] = lv
+	}
+
+	out[k] = v
+
+	return out
+}
+
+func (t *TSDB) seriesNamed(name string) []*tsSeries {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	out := make([]*tsSeries, 0)
+
+	for _, s := range t.series {
+		if s.name == name {
+			out = append(out, cloneSeries(s))
+		}
+	}
+
+	return out
+}
+
+func cloneSeries(s *tsSeries) *tsSeries {
+	points := make([]point, len(s.points))
+	copy(points, s.points)
+
+	return &tsSeries{name: s.name, labels: s.labels, points: points}
+}
+
+type apiResponse struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type queryData struct {
+	ResultType string       `json:"resultType"`
+	Result     []resultItem `json:"result"`
+}
+
+type resultItem struct {
+	Metric map[string]string `json:"metric"`
+	Value  *[2]interface{}   `json:"value,omitempty"`
+	Values [][2]interface{}  `json:"values,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, resp apiResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp) // nolint:errcheck
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// QueryHandler implements a PromQL-compatible /api/v1/query: the "query"
+// parameter is taken as a bare metric name and the latest retained sample
+// for every matching series is returned as an instant vector.
+func (t *TSDB) QueryHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("query")
+
+	series := t.seriesNamed(name)
+	result := make([]resultItem, 0, len(series))
+
+	for _, s := range series {
+		if len(s.points) == 0 {
+			continue
+		}
+
+		last := s.points[len(s.points)-1]
+		value := [2]interface{}{float64(last.t.Unix()), formatValue(last.v)}
+		result = append(result, resultItem{Metric: s.labels, Value: &value})
+	}
+
+	writeJSON(w, apiResponse{Status: "success", Data: queryData{ResultType: "vector", Result: result}})
+}
+
+// QueryRangeHandler implements /api/v1/query_range: the "query" parameter is
+// taken as a bare metric name and every retained sample for every matching
+// series is returned as a range vector (matrix).
+func (t *TSDB) QueryRangeHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("query")
+
+	series := t.seriesNamed(name)
+	result := make([]resultItem, 0, len(series))
+
+	for _, s := range series {
+		if len(s.points) == 0 {
+			continue
+		}
+
+		values := make([][2]interface{}, 0, len(s.points))
+		for _, p := range s.points {
+			values = append(values, [2]interface{}{float64(p.t.Unix()), formatValue(p.v)})
+		}
+
+		result = append(result, resultItem{Metric: s.labels, Values: values})
+	}
+
+	writeJSON(w, apiResponse{Status: "success", Data: queryData{ResultType: "matrix", Result: result}})
+}
+
+// LabelValuesHandler implements /api/v1/label/__name__/values, listing every
+// metric name currently retained by the TSDB.
+func (t *TSDB) LabelValuesHandler(w http.ResponseWriter, r *http.Request) {
+	t.mu.RLock()
+
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(t.series))
+
+	for _, s := range t.series {
+		if !seen[s.name] {
+			seen[s.name] = true
+
+			names = append(names, s.name)
+		}
+	}
+
+	t.mu.RUnlock()
+
+	sort.Strings(names)
+	writeJSON(w, apiResponse{Status: "success", Data: names})
+}
+
+// SeriesHandler implements /api/v1/series, listing the label set of every
+// series currently retained by the TSDB.
+func (t *TSDB) SeriesHandler(w http.ResponseWriter, r *http.Request) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := make([]map[string]string, 0, len(t.series))
+
+	for _, s := range t.series {
+		m := make(map[string]string, len(s.labels)+1)
+		for k, v := range s.labels {
+			m[k] = v
+		}
+
+		m["__name__"] = s.name
+		result = append(result, m)
+	}
+
+	writeJSON(w, apiResponse{Status: "success", Data: result})
+}
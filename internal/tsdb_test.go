@@ -0,0 +1,170 @@
+// MIT License
+//
+// Copyright (c) 2021 Iván Szkiba
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestExpandMetricHistogram(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	h := NewHistogram(registry, "", "", "req_duration", "help", []float64{1, 2}, false)
+
+	if err := registry.Register(h); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	h.Observe(0.5)
+	h.Observe(1.5)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+
+	var samples []expandedSample
+
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			samples = append(samples, expandMetric(mf, m)...)
+		}
+	}
+
+	// 2 buckets + +Inf + _sum + _count.
+	want := 5
+	if len(samples) != want {
+		t.Fatalf("expected %d expanded samples, got %d", want, len(samples))
+	}
+
+	seen := map[string]bool{}
+	for _, s := range samples {
+		seen[s.name+":"+s.labels["le"]] = true
+	}
+
+	if !seen["req_duration_sum:"] || !seen["req_duration_count:"] {
+		t.Fatalf("missing _sum/_count samples: %+v", samples)
+	}
+}
+
+func TestQueryHandlerVectorShape(t *testing.T) {
+	tsdb := NewTSDB(prometheus.NewRegistry())
+	tsdb.series["x"] = &tsSeries{
+		name:   "http_reqs",
+		labels: map[string]string{"method": "GET"},
+		points: []point{{v: 42}},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/query?query=http_reqs", nil)
+	rec := httptest.NewRecorder()
+
+	tsdb.QueryHandler(rec, req)
+
+	var resp struct {
+		Status string `json:"status"`
+		Data   struct {
+			ResultType string `json:"resultType"`
+			Result     []struct {
+				Value *[2]interface{} `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if resp.Status != "success" {
+		t.Fatalf("expected success status, got %q", resp.Status)
+	}
+
+	if resp.Data.ResultType != "vector" {
+		t.Fatalf("expected vector resultType, got %q", resp.Data.ResultType)
+	}
+
+	if len(resp.Data.Result) != 1 || resp.Data.Result[0].Value == nil {
+		t.Fatalf("unexpected result shape: %+v", resp.Data.Result)
+	}
+}
+
+func TestQueryRangeHandlerMatrixShape(t *testing.T) {
+	tsdb := NewTSDB(prometheus.NewRegistry())
+	tsdb.series["x"] = &tsSeries{
+		name:   "http_reqs",
+		labels: map[string]string{"method": "GET"},
+		points: []point{{v: 1}, {v: 2}},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/query_range?query=http_reqs", nil)
+	rec := httptest.NewRecorder()
+
+	tsdb.QueryRangeHandler(rec, req)
+
+	var resp struct {
+		Status string `json:"status"`
+		Data   struct {
+			ResultType string `json:"resultType"`
+			Result     []struct {
+				Values [][2]interface{} `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if resp.Data.ResultType != "matrix" {
+		t.Fatalf("expected matrix resultType, got %q", resp.Data.ResultType)
+	}
+
+	if len(resp.Data.Result) != 1 || len(resp.Data.Result[0].Values) != 2 {
+		t.Fatalf("unexpected result shape: %+v", resp.Data.Result)
+	}
+
+	// A matrix response must not carry a "value" field alongside "values" -
+	// strict Prometheus-API consumers (e.g. Grafana's datasource) branch on
+	// which of the two is present.
+	var raw struct {
+		Data struct {
+			Result []json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("unmarshal raw: %v", err)
+	}
+
+	var item map[string]json.RawMessage
+	if err := json.Unmarshal(raw.Data.Result[0], &item); err != nil {
+		t.Fatalf("unmarshal result item: %v", err)
+	}
+
+	if _, ok := item["value"]; ok {
+		t.Fatalf("matrix result item must not contain a \"value\" field: %s", raw.Data.Result[0])
+	}
+}